@@ -4,9 +4,13 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-interpreter/wagon/exec"
@@ -23,11 +27,159 @@ type Go struct {
 
 	values     map[ref]Value
 	valueIndex ref
+
+	refs      map[interface{}]ref
+	refCounts map[ref]int
+	freeIDs   []ref
+
+	timeouts      map[ref]*time.Timer
+	nextTimeoutID ref
+
+	jsGo  *jsGo
+	fsObj *jsFS
+
+	vm          *exec.VM
+	resumeIndex int64
+	vmMu        sync.Mutex // serializes resume()/Invoke(): wagon's exec.VM has no internal locking, and concurrent ExecCode calls race on the interpreter stack
+
+	logger Logger
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Logger receives diagnostics from a Go module. Tracef/Debugf carry
+// per-call tracing that is expensive enough to gate behind WithTrace;
+// Warnf/Errorf are always delivered.
+type Logger interface {
+	Tracef(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
 }
 
-func stub(name string) func(proc *exec.Process, sp int32) {
+// stdLogger is the default Logger, writing through the standard log
+// package. Tracef/Debugf are no-ops unless trace is enabled.
+type stdLogger struct {
+	trace bool
+	*log.Logger
+}
+
+func (l *stdLogger) Tracef(format string, args ...interface{}) {
+	if !l.trace {
+		return
+	}
+	l.Printf("TRACE "+format, args...)
+}
+
+func (l *stdLogger) Debugf(format string, args ...interface{}) {
+	if !l.trace {
+		return
+	}
+	l.Printf("DEBUG "+format, args...)
+}
+
+func (l *stdLogger) Warnf(format string, args ...interface{}) {
+	l.Printf("WARN "+format, args...)
+}
+
+func (l *stdLogger) Errorf(format string, args ...interface{}) {
+	l.Printf("ERROR "+format, args...)
+}
+
+// Option configures a Go module created via NewGoWithOptions.
+type Option func(*Go)
+
+// WithLogger overrides the module's Logger. The default logs through the
+// standard log package with tracing disabled.
+func WithLogger(logger Logger) Option {
+	return func(g *Go) { g.logger = logger }
+}
+
+// WithTrace toggles Tracef/Debugf output on the default Logger. It has no
+// effect when combined with WithLogger, since a custom Logger owns its
+// own verbosity.
+func WithTrace(trace bool) Option {
+	return func(g *Go) {
+		if l, ok := g.logger.(*stdLogger); ok {
+			l.trace = trace
+		}
+	}
+}
+
+// WithStdout overrides where fd 1 (stdout) writes go, both for
+// runtime.wasmWrite and for the default "fs" global.
+func WithStdout(w io.Writer) Option {
+	return func(g *Go) { g.stdout = w }
+}
+
+// WithStderr overrides where fd 2 (stderr) writes go, both for
+// runtime.wasmWrite and for the default "fs" global.
+func WithStderr(w io.Writer) Option {
+	return func(g *Go) { g.stderr = w }
+}
+
+// SetFS overrides the filesystem backing the emulated "fs" global. The
+// default implementation only allows writing to stdout/stderr (fds 1 and
+// 2) and reports everything else as unimplemented.
+func (g *Go) SetFS(fs FS) {
+	g.fsObj.fs = fs
+}
+
+// Bind attaches the running guest VM (and the function index of its
+// exported "resume" trampoline) so that host code can call back into
+// guest-registered js.FuncOf callbacks via Invoke, and so that fired
+// timeout events can drain the guest's event loop.
+func (g *Go) Bind(vm *exec.VM, resumeFuncIndex int64) {
+	g.vm = vm
+	g.resumeIndex = resumeFuncIndex
+}
+
+// resumeLocked executes the guest's "resume" trampoline. Callers must hold
+// g.vmMu: the VM has no internal locking of its own.
+func (g *Go) resumeLocked() error {
+	if g.vm == nil {
+		return nil
+	}
+	_, err := g.vm.ExecCode(g.resumeIndex)
+	return err
+}
+
+// Invoke calls a js.FuncOf callback the guest registered, by draining the
+// guest's event loop through the same _pendingEvent/resume mechanism
+// wasm_exec.js uses for browser event callbacks. It holds g.vmMu for the
+// whole set-event-then-resume sequence, so a concurrent Invoke or fired
+// timeout can't clobber g.jsGo.pendingEvent before this one is drained,
+// and two goroutines never call into the VM at once.
+func (g *Go) Invoke(fn Value, args ...interface{}) (interface{}, error) {
+	if g.vm == nil {
+		return nil, fmt.Errorf("modules: Go module is not bound to a running VM")
+	}
+
+	wrapper, ok := fn.v.(funcWrapper)
+	if !ok {
+		return nil, fmt.Errorf("modules: value %v is not a callback function", fn)
+	}
+
+	g.vmMu.Lock()
+	defer g.vmMu.Unlock()
+
+	event := jsObject{
+		"id":   float64(wrapper.id),
+		"this": g.values[jsValueGlobal].v,
+		"args": jsArray(args),
+	}
+	g.jsGo.pendingEvent = event
+
+	if err := g.resumeLocked(); err != nil {
+		return nil, err
+	}
+
+	return event["result"], nil
+}
+
+func (g *Go) stub(name string) func(proc *exec.Process, sp int32) {
 	return func(proc *exec.Process, sp int32) {
-		fmt.Println("called", name, "with val", sp)
+		g.logger.Tracef("called %s with val %d", name, sp)
 	}
 }
 
@@ -42,6 +194,10 @@ func (o jsObject) Get(v string) interface{} {
 	return o[v]
 }
 
+func (o jsObject) Set(v string, val interface{}) {
+	o[v] = val
+}
+
 func newJSError(err error) jsObject {
 	return jsObject{"message": err.Error()}
 }
@@ -51,34 +207,599 @@ type jsInt8Array []int8
 func (a jsInt8Array) New(args ...interface{}) interface{} {
 	a = make(jsInt8Array, len(args))
 	for i, v := range args {
-		a[i] = v.(int8)
+		a[i] = int8(toFloat64(v))
+	}
+	return a
+}
+
+func (a jsInt8Array) Index(i int) interface{} {
+	return float64(a[i])
+}
+
+func (a jsInt8Array) SetIndex(i int, val interface{}) {
+	a[i] = int8(toFloat64(val))
+}
+
+func (a jsInt8Array) Length() int {
+	return len(a)
+}
+
+type jsUint8Array []byte
+
+func (a jsUint8Array) New(args ...interface{}) interface{} {
+	a = make(jsUint8Array, len(args))
+	for i, v := range args {
+		a[i] = byte(toFloat64(v))
+	}
+	return a
+}
+
+func (a jsUint8Array) Index(i int) interface{} {
+	return float64(a[i])
+}
+
+func (a jsUint8Array) SetIndex(i int, val interface{}) {
+	a[i] = byte(toFloat64(val))
+}
+
+func (a jsUint8Array) Length() int {
+	return len(a)
+}
+
+type jsInt16Array []int16
+
+func (a jsInt16Array) New(args ...interface{}) interface{} {
+	a = make(jsInt16Array, len(args))
+	for i, v := range args {
+		a[i] = int16(toFloat64(v))
+	}
+	return a
+}
+
+func (a jsInt16Array) Index(i int) interface{} { return float64(a[i]) }
+
+func (a jsInt16Array) SetIndex(i int, val interface{}) { a[i] = int16(toFloat64(val)) }
+
+func (a jsInt16Array) Length() int { return len(a) }
+
+type jsUint16Array []uint16
+
+func (a jsUint16Array) New(args ...interface{}) interface{} {
+	a = make(jsUint16Array, len(args))
+	for i, v := range args {
+		a[i] = uint16(toFloat64(v))
+	}
+	return a
+}
+
+func (a jsUint16Array) Index(i int) interface{} { return float64(a[i]) }
+
+func (a jsUint16Array) SetIndex(i int, val interface{}) { a[i] = uint16(toFloat64(val)) }
+
+func (a jsUint16Array) Length() int { return len(a) }
+
+type jsInt32Array []int32
+
+func (a jsInt32Array) New(args ...interface{}) interface{} {
+	a = make(jsInt32Array, len(args))
+	for i, v := range args {
+		a[i] = int32(toFloat64(v))
+	}
+	return a
+}
+
+func (a jsInt32Array) Index(i int) interface{} { return float64(a[i]) }
+
+func (a jsInt32Array) SetIndex(i int, val interface{}) { a[i] = int32(toFloat64(val)) }
+
+func (a jsInt32Array) Length() int { return len(a) }
+
+type jsUint32Array []uint32
+
+func (a jsUint32Array) New(args ...interface{}) interface{} {
+	a = make(jsUint32Array, len(args))
+	for i, v := range args {
+		a[i] = uint32(toFloat64(v))
+	}
+	return a
+}
+
+func (a jsUint32Array) Index(i int) interface{} { return float64(a[i]) }
+
+func (a jsUint32Array) SetIndex(i int, val interface{}) { a[i] = uint32(toFloat64(val)) }
+
+func (a jsUint32Array) Length() int { return len(a) }
+
+type jsFloat32Array []float32
+
+func (a jsFloat32Array) New(args ...interface{}) interface{} {
+	a = make(jsFloat32Array, len(args))
+	for i, v := range args {
+		a[i] = float32(toFloat64(v))
 	}
 	return a
 }
 
+func (a jsFloat32Array) Index(i int) interface{} { return float64(a[i]) }
+
+func (a jsFloat32Array) SetIndex(i int, val interface{}) { a[i] = float32(toFloat64(val)) }
+
+func (a jsFloat32Array) Length() int { return len(a) }
+
+type jsFloat64Array []float64
+
+func (a jsFloat64Array) New(args ...interface{}) interface{} {
+	a = make(jsFloat64Array, len(args))
+	for i, v := range args {
+		a[i] = toFloat64(v)
+	}
+	return a
+}
+
+func (a jsFloat64Array) Index(i int) interface{} { return a[i] }
+
+func (a jsFloat64Array) SetIndex(i int, val interface{}) { a[i] = toFloat64(val) }
+
+func (a jsFloat64Array) Length() int { return len(a) }
+
+// FS is the filesystem surface the emulated "fs" global needs, keyed by
+// file descriptor the same way the guest's syscall/js calls are: Open
+// allocates one, the rest operate on it until Close. The default
+// implementation (stdFS) only serves stdout/stderr through WriteAt;
+// everything else reports an error, matching the Node-less fallback
+// wasm_exec.js itself ships. SetFS swaps in a real implementation (e.g.
+// backed by afero.Fs or *os.File) to back open/close/read/fstat/stat
+// with an actual filesystem.
+type FS interface {
+	WriteAt(fd int, p []byte) (int, error)
+	ReadAt(fd int, p []byte) (int, error)
+	Open(path string, flags int, mode uint32) (fd int, err error)
+	Close(fd int) error
+	Fstat(fd int) (os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+}
+
+// stdFS is the default FS: fd 1 and 2 go to stdout/stderr, everything
+// else is denied.
+type stdFS struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (f *stdFS) WriteAt(fd int, p []byte) (int, error) {
+	switch fd {
+	case 1:
+		return f.stdout.Write(p)
+	case 2:
+		return f.stderr.Write(p)
+	default:
+		return 0, fmt.Errorf("modules: fd %d is not open for writing", fd)
+	}
+}
+
+func (f *stdFS) ReadAt(fd int, p []byte) (int, error) {
+	return 0, fmt.Errorf("modules: fd %d is not open for reading", fd)
+}
+
+func (f *stdFS) Open(path string, flags int, mode uint32) (int, error) {
+	return 0, fmt.Errorf("modules: open %q: not implemented", path)
+}
+
+func (f *stdFS) Close(fd int) error {
+	return fmt.Errorf("modules: fd %d is not open", fd)
+}
+
+func (f *stdFS) Fstat(fd int) (os.FileInfo, error) {
+	return nil, fmt.Errorf("modules: fd %d is not open", fd)
+}
+
+func (f *stdFS) Stat(path string) (os.FileInfo, error) {
+	return nil, fmt.Errorf("modules: stat %q: not implemented", path)
+}
+
+func enosysError() jsObject {
+	return jsObject{"code": "ENOSYS", "message": "function not implemented"}
+}
+
+// jsFS backs the "fs" global. writeSync/write/open/close/read/fstat/stat
+// reach the configured FS; fsync reports success without reaching it.
+// The other Node fs methods listed in Get report ENOSYS, since that's
+// also what wasm_exec.js falls back to outside of a JS host with a real
+// filesystem; any name not listed there is undefined (nil).
+type jsFS struct {
+	fs     FS
+	invoke func(fn interface{}, args ...interface{})
+}
+
+func (o *jsFS) Get(v string) interface{} {
+	switch v {
+	case "constants":
+		return jsObject{
+			"O_WRONLY": float64(os.O_WRONLY),
+			"O_RDWR":   float64(os.O_RDWR),
+			"O_CREAT":  float64(os.O_CREATE),
+			"O_TRUNC":  float64(os.O_TRUNC),
+			"O_APPEND": float64(os.O_APPEND),
+			"O_EXCL":   float64(os.O_EXCL),
+		}
+	case "writeSync":
+		return jsFunc(o.writeSync)
+	case "write":
+		return jsFunc(o.write)
+	case "fsync":
+		return jsFunc(o.fsync)
+	case "open":
+		return jsFunc(o.open)
+	case "close":
+		return jsFunc(o.close)
+	case "read":
+		return jsFunc(o.read)
+	case "fstat":
+		return jsFunc(o.fstat)
+	case "stat":
+		return jsFunc(o.stat)
+	case "chmod", "chown", "fchmod", "fchown", "lchown", "link", "lstat",
+		"mkdir", "readdir", "readlink", "rename", "rmdir", "symlink",
+		"truncate", "unlink", "utimes":
+		return jsFunc(o.enosys)
+	default:
+		return nil
+	}
+}
+
+func (o *jsFS) writeSync(args ...interface{}) interface{} {
+	fd := int(toFloat64(args[0]))
+	buf, _ := args[1].(jsUint8Array)
+
+	n, _ := o.fs.WriteAt(fd, buf)
+	return float64(n)
+}
+
+func (o *jsFS) write(args ...interface{}) interface{} {
+	fd := int(toFloat64(args[0]))
+	buf, _ := args[1].(jsUint8Array)
+	offset := int(toFloat64(args[2]))
+	length := int(toFloat64(args[3]))
+	callback := args[5]
+
+	if offset != 0 || length != len(buf) || args[4] != nil {
+		o.invoke(callback, enosysError())
+		return nil
+	}
+
+	n, err := o.fs.WriteAt(fd, buf)
+	if err != nil {
+		o.invoke(callback, newJSError(err))
+		return nil
+	}
+
+	o.invoke(callback, nil, float64(n))
+	return nil
+}
+
+func (o *jsFS) fsync(args ...interface{}) interface{} {
+	o.invoke(args[len(args)-1], nil)
+	return nil
+}
+
+func (o *jsFS) open(args ...interface{}) interface{} {
+	path, _ := args[0].(string)
+	flags := int(toFloat64(args[1]))
+	mode := uint32(toFloat64(args[2]))
+	callback := args[3]
+
+	fd, err := o.fs.Open(path, flags, mode)
+	if err != nil {
+		o.invoke(callback, newJSError(err))
+		return nil
+	}
+
+	o.invoke(callback, nil, float64(fd))
+	return nil
+}
+
+func (o *jsFS) close(args ...interface{}) interface{} {
+	fd := int(toFloat64(args[0]))
+	callback := args[1]
+
+	if err := o.fs.Close(fd); err != nil {
+		o.invoke(callback, newJSError(err))
+		return nil
+	}
+
+	o.invoke(callback, nil)
+	return nil
+}
+
+func (o *jsFS) read(args ...interface{}) interface{} {
+	fd := int(toFloat64(args[0]))
+	buf, _ := args[1].(jsUint8Array)
+	offset := int(toFloat64(args[2]))
+	length := int(toFloat64(args[3]))
+	callback := args[5]
+
+	if args[4] != nil || offset < 0 || length < 0 || offset+length > len(buf) {
+		o.invoke(callback, enosysError())
+		return nil
+	}
+
+	n, err := o.fs.ReadAt(fd, buf[offset:offset+length])
+	if err != nil {
+		o.invoke(callback, newJSError(err))
+		return nil
+	}
+
+	o.invoke(callback, nil, float64(n))
+	return nil
+}
+
+func (o *jsFS) fstat(args ...interface{}) interface{} {
+	fd := int(toFloat64(args[0]))
+	callback := args[1]
+
+	info, err := o.fs.Fstat(fd)
+	if err != nil {
+		o.invoke(callback, newJSError(err))
+		return nil
+	}
+
+	o.invoke(callback, nil, fileInfoToJS(info))
+	return nil
+}
+
+func (o *jsFS) stat(args ...interface{}) interface{} {
+	path, _ := args[0].(string)
+	callback := args[1]
+
+	info, err := o.fs.Stat(path)
+	if err != nil {
+		o.invoke(callback, newJSError(err))
+		return nil
+	}
+
+	o.invoke(callback, nil, fileInfoToJS(info))
+	return nil
+}
+
+// fileInfoToJS converts an os.FileInfo into the subset of Node's
+// fs.Stats shape guest code typically reads off fstat/stat results.
+func fileInfoToJS(info os.FileInfo) jsObject {
+	isDir := info.IsDir()
+	return jsObject{
+		"mode":        float64(info.Mode()),
+		"size":        float64(info.Size()),
+		"mtimeMs":     float64(info.ModTime().UnixMilli()),
+		"isDirectory": jsFunc(func(args ...interface{}) interface{} { return isDir }),
+		"isFile":      jsFunc(func(args ...interface{}) interface{} { return !isDir }),
+	}
+}
+
+func (o *jsFS) enosys(args ...interface{}) interface{} {
+	o.invoke(args[len(args)-1], enosysError())
+	return nil
+}
+
+// jsProcess backs the "process" global.
+type jsProcess struct {
+	wd string
+}
+
+func newJSProcess() *jsProcess {
+	wd, _ := os.Getwd()
+	return &jsProcess{wd: wd}
+}
+
+func (p *jsProcess) Get(v string) interface{} {
+	switch v {
+	case "pid":
+		return float64(os.Getpid())
+	case "env":
+		return processEnv()
+	case "getuid":
+		return jsFunc(func(args ...interface{}) interface{} { return float64(os.Getuid()) })
+	case "geteuid":
+		return jsFunc(func(args ...interface{}) interface{} { return float64(os.Geteuid()) })
+	case "getgid":
+		return jsFunc(func(args ...interface{}) interface{} { return float64(os.Getgid()) })
+	case "getegid":
+		return jsFunc(func(args ...interface{}) interface{} { return float64(os.Getegid()) })
+	case "getgroups":
+		return jsFunc(func(args ...interface{}) interface{} { return jsArray{} })
+	case "umask":
+		return jsFunc(func(args ...interface{}) interface{} { return float64(0) })
+	case "cwd":
+		return jsFunc(func(args ...interface{}) interface{} { return p.wd })
+	case "chdir":
+		return jsFunc(p.chdir)
+	default:
+		return nil
+	}
+}
+
+func (p *jsProcess) chdir(args ...interface{}) interface{} {
+	if len(args) > 0 {
+		if dir, ok := args[0].(string); ok {
+			p.wd = dir
+		}
+	}
+	return nil
+}
+
+func processEnv() jsObject {
+	env := jsObject{}
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
+
+var jsCrypto = jsObject{
+	"getRandomValues": jsFunc(func(args ...interface{}) interface{} {
+		if len(args) == 0 {
+			return nil
+		}
+		buf, ok := args[0].(jsUint8Array)
+		if !ok {
+			return args[0]
+		}
+		rand.Read(buf)
+		return args[0]
+	}),
+}
+
+// jsFunc adapts a plain Go func into something the getter/invoker
+// machinery can store and call, mirroring how wasm_exec.js hands the
+// guest plain JS functions as callable values.
+type jsFunc func(args ...interface{}) interface{}
+
+func (f jsFunc) Invoke(args ...interface{}) interface{} {
+	return f(args...)
+}
+
+// funcWrapper is the value returned to the guest by jsGo's
+// _makeFuncWrapper, recording the id of the js.FuncOf callback it wraps so
+// that host code can later drive it through (*Go).Invoke.
+type funcWrapper struct {
+	id ref
+}
+
+// jsGo mirrors the "go" instance wasm_exec.js installs at ref jsVsGo: a
+// mutable _pendingEvent slot the guest's resume export drains, and the
+// _makeFuncWrapper constructor the guest calls when it registers a
+// js.FuncOf callback.
+type jsGo struct {
+	pendingEvent jsObject
+}
+
+func (g *jsGo) Get(v string) interface{} {
+	switch v {
+	case "_pendingEvent":
+		if g.pendingEvent == nil {
+			return nil
+		}
+		return g.pendingEvent
+	case "_makeFuncWrapper":
+		return jsFunc(g.makeFuncWrapper)
+	default:
+		return nil
+	}
+}
+
+func (g *jsGo) Set(v string, val interface{}) {
+	if v != "_pendingEvent" {
+		return
+	}
+
+	if val == nil {
+		g.pendingEvent = nil
+		return
+	}
+
+	g.pendingEvent, _ = val.(jsObject)
+}
+
+func (g *jsGo) makeFuncWrapper(args ...interface{}) interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	return funcWrapper{id: ref(toFloat64(args[0]))}
+}
+
 type jsArray []interface{}
 
 func (a jsArray) New(args ...interface{}) interface{} {
 	return jsArray(args)
 }
 
+func (a jsArray) Index(i int) interface{} {
+	return a[i]
+}
+
+func (a jsArray) SetIndex(i int, val interface{}) {
+	a[i] = val
+}
+
+func (a jsArray) Length() int {
+	return len(a)
+}
+
 type getter interface {
 	Get(v string) interface{}
 }
 
+type setter interface {
+	Set(v string, val interface{})
+}
+
+type indexer interface {
+	Index(i int) interface{}
+}
+
+type indexSetter interface {
+	SetIndex(i int, val interface{})
+}
+
+type invoker interface {
+	Invoke(args ...interface{}) interface{}
+}
+
+type lengther interface {
+	Length() int
+}
+
 type newer interface {
 	New(args ...interface{}) interface{}
 }
 
+func toFloat64(v interface{}) float64 {
+	switch tv := v.(type) {
+	case float64:
+		return tv
+	case int:
+		return float64(tv)
+	case int8:
+		return float64(tv)
+	case int32:
+		return float64(tv)
+	case int64:
+		return float64(tv)
+	case bool:
+		if tv {
+			return 1
+		}
+		return 0
+	default:
+		return 0
+	}
+}
+
 var (
 	nan       = struct{}{}
 	undefined = struct{}{}
-	jsGlobal  = jsObject{
-		"Array":     jsArray{},
-		"Int8Array": jsInt8Array{},
-	}
 )
 
+// newJSGlobal builds the "globalThis" object exposed to the guest at ref
+// jsValueGlobal. It is rebuilt per Go instance (rather than shared) since
+// process and fs carry per-instance state.
+func newJSGlobal(proc *jsProcess, fsObj *jsFS) jsObject {
+	return jsObject{
+		"Array":        jsArray{},
+		"Int8Array":    jsInt8Array{},
+		"Uint8Array":   jsUint8Array{},
+		"Int16Array":   jsInt16Array{},
+		"Uint16Array":  jsUint16Array{},
+		"Int32Array":   jsInt32Array{},
+		"Uint32Array":  jsUint32Array{},
+		"Float32Array": jsFloat32Array{},
+		"Float64Array": jsFloat64Array{},
+		"process":      proc,
+		"fs":           fsObj,
+		"crypto":       jsCrypto,
+	}
+}
+
 const (
 	jsValueNaN       = 0
 	jsValueUndefined = 1
@@ -96,25 +817,68 @@ var defaultValues = map[ref]Value{
 	jsValueNull:      Value{ref: jsValueNull, v: nil},
 	jsValueTrue:      Value{ref: jsValueTrue, v: true},
 	jsValueFalse:     Value{ref: jsValueFalse, v: false},
-	jsValueGlobal:    Value{ref: jsValueGlobal, v: jsGlobal},
+	jsValueGlobal:    Value{ref: jsValueGlobal, v: jsObject{}}, // replaced per-instance in NewGo
 	jsValueMemory:    Value{ref: jsValueMemory, v: jsValueMemory},
 	jsVsGo:           Value{ref: jsVsGo, v: jsVsGo},
 }
 
 // NewGo creates a new Go WASM runtime module
 func NewGo() (*Go, error) {
+	return NewGoWithOptions()
+}
+
+// NewGoWithOptions creates a new Go WASM runtime module configured with
+// the given Options (WithLogger, WithStdout, WithStderr, WithTrace). With
+// no options it behaves exactly like NewGo.
+func NewGoWithOptions(opts ...Option) (*Go, error) {
+	values := make(map[ref]Value, len(defaultValues))
+	for k, v := range defaultValues {
+		values[k] = v
+	}
+
+	goObj := &jsGo{}
+	values[jsVsGo] = Value{ref: jsVsGo, v: goObj}
+
 	g := &Go{
 		Module:     wasm.NewModule(),
 		timeOrigin: time.Now(),
-		values:     defaultValues,
-		valueIndex: ref(len(defaultValues)),
+		values:     values,
+		valueIndex: ref(len(values)),
+		refs:       map[interface{}]ref{},
+		refCounts:  map[ref]int{},
+		timeouts:   map[ref]*time.Timer{},
+		jsGo:       goObj,
+		logger:     &stdLogger{Logger: log.New(os.Stderr, "", log.LstdFlags)},
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+	}
+
+	for _, opt := range opts {
+		opt(g)
 	}
 
+	g.fsObj = &jsFS{fs: &stdFS{stdout: g.stdout, stderr: g.stderr}, invoke: g.invokeCallback}
+	g.values[jsValueGlobal] = Value{ref: jsValueGlobal, v: newJSGlobal(newJSProcess(), g.fsObj)}
+
+	g.seedReservedRef(jsValueGlobal, g.values[jsValueGlobal].v)
+	g.seedReservedRef(jsVsGo, goObj)
+
 	g.loadExports()
 
 	return g, nil
 }
 
+// invokeCallback drives a Node-style (err, result...) callback the guest
+// passed as a function argument, e.g. to an "fs" method.
+func (g *Go) invokeCallback(fn interface{}, args ...interface{}) {
+	if fn == nil {
+		return
+	}
+	if _, err := g.Invoke(Value{v: fn}, args...); err != nil {
+		g.logger.Warnf("invokeCallback: resume failed: %v", err)
+	}
+}
+
 func (g *Go) loadExports() {
 	exports := []struct {
 		Name    string
@@ -122,20 +886,30 @@ func (g *Go) loadExports() {
 		Params  []wasm.ValueType
 		Returns []wasm.ValueType
 	}{
-		{Name: "debug", Func: stub("debug"), Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "debug", Func: g.stub("debug"), Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "runtime.wasmExit", Func: g.exportExit, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "runtime.wasmWrite", Func: g.exportWasmWrite, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "runtime.nanotime", Func: g.exportNanotime, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "runtime.walltime", Func: g.exportWalltime, Params: []wasm.ValueType{wasm.ValueTypeI32}},
-		{Name: "runtime.scheduleCallback", Func: stub("runtime.scheduleCallback"), Params: []wasm.ValueType{wasm.ValueTypeI32}},
-		{Name: "runtime.clearScheduledCallback", Func: stub("runtime.clearScheduledCallback"), Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "runtime.scheduleCallback", Func: g.stub("runtime.scheduleCallback"), Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "runtime.clearScheduledCallback", Func: g.stub("runtime.clearScheduledCallback"), Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "runtime.getRandomData", Func: g.exportGetRandomData, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "syscall/js.stringVal", Func: g.exportStringVal, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "syscall/js.valueGet", Func: g.exportValueGet, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "syscall/js.valueSet", Func: g.exportValueSet, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "syscall/js.valueIndex", Func: g.exportValueIndex, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "syscall/js.valueSetIndex", Func: g.exportValueSetIndex, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "syscall/js.valueLength", Func: g.exportValueLength, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "syscall/js.valueCall", Func: g.exportValueCall, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "syscall/js.valueInvoke", Func: g.exportValueInvoke, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "syscall/js.valueNew", Func: g.exportValueNew, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "syscall/js.valuePrepareString", Func: g.exportValuePrepareString, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 		{Name: "syscall/js.valueLoadString", Func: g.exportValueLoadString, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "syscall/js.copyBytesToGo", Func: g.exportCopyBytesToGo, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "syscall/js.copyBytesToJS", Func: g.exportCopyBytesToJS, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "syscall/js.finalizeRef", Func: g.exportFinalizeRef, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "runtime.scheduleTimeoutEvent", Func: g.exportScheduleTimeoutEvent, Params: []wasm.ValueType{wasm.ValueTypeI32}},
+		{Name: "runtime.clearTimeoutEvent", Func: g.exportClearTimeoutEvent, Params: []wasm.ValueType{wasm.ValueTypeI32}},
 	}
 
 	g.Export.Entries = map[string]wasm.ExportEntry{}
@@ -260,17 +1034,18 @@ func (g *Go) loadString(ba BufferAt, sp int32) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	fmt.Println("loaded string", string(buf))
+	g.logger.Tracef("loaded string %q", string(buf))
 	return string(buf), nil
 }
 
 func (g *Go) loadValue(ba BufferAt, addr int32) Value {
 	r := g.getRef(ba, addr)
-	fmt.Println("getting id", r)
-	if int(r) > len(g.values) {
+	g.logger.Tracef("getting id %d", r)
+	v, ok := g.values[r]
+	if !ok {
 		return g.values[jsValueUndefined] // this is how javascript acts when index out of bounds occurs
 	}
-	return g.values[r]
+	return v
 }
 
 func (g *Go) loadSliceOfValues(ba BufferAt, addr int32) []interface{} {
@@ -280,15 +1055,88 @@ func (g *Go) loadSliceOfValues(ba BufferAt, addr int32) []interface{} {
 	array := make([]interface{}, length)
 	for i := int64(0); i < length; i++ {
 		id := g.getRef(ba, int32(arrayAddr+i*4))
-		array[i] = g.values[id]
+		array[i] = g.values[id].v
 	}
 	return array
 }
 
+// refIdentity is a comparable stand-in for the reference-semantics kinds
+// (map, slice, chan) that Go's == operator refuses to use as a map key.
+// Two refIdentity values compare equal iff they have the same type and
+// the same underlying map/chan header or slice data pointer, i.e. the same
+// JS-side object identity wasm_exec.js dedupes on.
+type refIdentity struct {
+	typ reflect.Type
+	ptr uintptr
+}
+
+// refKey returns the key to dedupe v under in the refs reverse index, and
+// whether v can be deduped at all. Composite values (jsObject, jsArray,
+// typed arrays, ...) key on their underlying data pointer, so the same
+// global or object fetched twice resolves to the same ref; two separately
+// constructed values that merely look alike do not dedupe, matching JS
+// reference-equality semantics.
+func refKey(v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Func:
+		// A func's Pointer is its code pointer, shared by every closure
+		// built from the same literal, so it cannot tell two funcs apart.
+		return nil, false
+	case reflect.Map, reflect.Slice, reflect.Chan:
+		if rv.IsNil() {
+			return nil, false
+		}
+		return refIdentity{typ: rv.Type(), ptr: rv.Pointer()}, true
+	default:
+		if !rv.Type().Comparable() {
+			return nil, false
+		}
+		return v, true
+	}
+}
+
+// reservedRefCount seeds refCounts for the fixed-id reserved values
+// (jsValueGlobal, jsVsGo) so they're never mistaken for finalizable,
+// mirroring wasm_exec.js seeding _goRefCounts with Infinity for the same
+// ids.
+const reservedRefCount = math.MaxInt32
+
+// seedReservedRef pre-populates the refs reverse index for one of the
+// fixed-id reserved values and marks it as effectively never finalized.
+// These values are handed out before the guest ever calls
+// js.Global()/js.FuncOf, so without this the first round-trip of v
+// through storeValue would mint a brand-new ref instead of deduping to
+// the reserved one -- and every subsequent round-trip would repeat that,
+// leaking a fresh duplicate ref each time.
+func (g *Go) seedReservedRef(r ref, v interface{}) {
+	if key, keyable := refKey(v); keyable {
+		g.refs[key] = r
+	}
+	g.refCounts[r] = reservedRefCount
+}
+
+// allocRef returns an unused ref id, preferring ids freed by finalizeRef
+// over growing the id space.
+func (g *Go) allocRef() ref {
+	if n := len(g.freeIDs); n > 0 {
+		r := g.freeIDs[n-1]
+		g.freeIDs = g.freeIDs[:n-1]
+		return r
+	}
+
+	r := g.valueIndex
+	g.valueIndex++
+	return r
+}
+
 func (g *Go) storeValue(ba BufferAt, addr int32, v interface{}) {
 	const nanHead = 0x7FF80000
 
-	fmt.Println("storeValue", addr, v)
+	g.logger.Tracef("storeValue %d %v", addr, v)
 
 	if v == nil {
 		g.setUInt32(ba, addr+4, nanHead)
@@ -325,13 +1173,25 @@ func (g *Go) storeValue(ba BufferAt, addr int32, v interface{}) {
 		}
 
 		r = tv.ref
+		if _, tracked := g.refCounts[r]; tracked {
+			g.refCounts[r]++
+		}
 	default:
-		r = g.valueIndex
-		g.values[r] = Value{
-			ref: r,
-			v:   tv,
+		key, keyable := refKey(tv)
+		if keyable {
+			if existing, ok := g.refs[key]; ok {
+				r = existing
+				g.refCounts[r]++
+				break
+			}
+		}
+
+		r = g.allocRef()
+		g.values[r] = Value{ref: r, v: tv}
+		g.refCounts[r] = 1
+		if keyable {
+			g.refs[key] = r
 		}
-		g.valueIndex++
 	}
 
 	const (
@@ -352,8 +1212,34 @@ func (g *Go) storeValue(ba BufferAt, addr int32, v interface{}) {
 	g.setRef(ba, addr, r)
 }
 
+func (g *Go) exportFinalizeRef(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportFinalizeRef")
+	id := g.getRef(proc, sp+8)
+
+	count, ok := g.refCounts[id]
+	if !ok {
+		return
+	}
+
+	count--
+	if count > 0 {
+		g.refCounts[id] = count
+		return
+	}
+
+	delete(g.refCounts, id)
+	v, ok := g.values[id]
+	delete(g.values, id)
+	if ok {
+		if key, keyable := refKey(v.v); keyable {
+			delete(g.refs, key)
+		}
+	}
+	g.freeIDs = append(g.freeIDs, id)
+}
+
 func (g *Go) exportValuePrepareString(proc *exec.Process, sp int32) {
-	fmt.Println("exportValuePrepareString")
+	g.logger.Tracef("exportValuePrepareString")
 	str, err := g.loadString(proc, sp+8)
 	if err != nil {
 		panic(err)
@@ -363,13 +1249,13 @@ func (g *Go) exportValuePrepareString(proc *exec.Process, sp int32) {
 }
 
 func (g *Go) exportValueLoadString(proc *exec.Process, sp int32) {
-	fmt.Println("exportValueLoadString")
+	g.logger.Tracef("exportValueLoadString")
 	str := g.loadValue(proc, sp+8)
 	g.setSlice(proc, sp+8, []byte(str.v.(string))) // will panic if not a string value
 }
 
 func (g *Go) exportStringVal(proc *exec.Process, sp int32) {
-	fmt.Println("exportStringVal")
+	g.logger.Tracef("exportStringVal")
 	s, err := g.loadString(proc, sp+8)
 	if err != nil {
 		panic(err)
@@ -378,7 +1264,7 @@ func (g *Go) exportStringVal(proc *exec.Process, sp int32) {
 }
 
 func (g *Go) exportValueNew(proc *exec.Process, sp int32) {
-	fmt.Println("exportValueNew")
+	g.logger.Tracef("exportValueNew")
 	v := g.loadValue(proc, sp+8)
 	args := g.loadSliceOfValues(proc, sp+16)
 
@@ -396,7 +1282,7 @@ func (g *Go) exportValueNew(proc *exec.Process, sp int32) {
 }
 
 func (g *Go) exportValueCall(proc *exec.Process, sp int32) {
-	fmt.Println("exportValueCall")
+	g.logger.Tracef("exportValueCall")
 
 	v := g.loadValue(proc, sp+8)
 	f, err := g.loadString(proc, sp+16)
@@ -404,21 +1290,30 @@ func (g *Go) exportValueCall(proc *exec.Process, sp int32) {
 		panic(err)
 	}
 
-	_, ok := v.v.(getter)
+	args := g.loadSliceOfValues(proc, sp+32)
+
+	gtr, ok := v.v.(getter)
 	if !ok {
-		fmt.Println(g.values)
-		log.Printf("value %v of type %v is not a getter wanting %q", v.ref, reflect.TypeOf(v.v), f)
-		proc.Terminate()
+		err := fmt.Errorf("value %v of type %q has no method %q", v, reflect.TypeOf(v.v), f)
+		g.storeValue(proc, sp+56, newJSError(err))
+		g.setUInt8(proc, sp+64, 0)
 		return
 	}
 
-	args := g.loadSliceOfValues(proc, sp+32)
+	m, ok := gtr.Get(f).(invoker)
+	if !ok {
+		err := fmt.Errorf("value %v of type %q has no callable method %q", v, reflect.TypeOf(v.v), f)
+		g.storeValue(proc, sp+56, newJSError(err))
+		g.setUInt8(proc, sp+64, 0)
+		return
+	}
 
-	fmt.Printf("Calling %q on %v with args %v\n", f, v, args)
+	g.storeValue(proc, sp+56, m.Invoke(args...))
+	g.setUInt8(proc, sp+64, 1)
 }
 
 func (g *Go) exportValueGet(proc *exec.Process, sp int32) {
-	fmt.Println("exportValueGet")
+	g.logger.Tracef("exportValueGet")
 	v := g.loadValue(proc, sp+8)
 
 	s, err := g.loadString(proc, sp+16)
@@ -428,8 +1323,7 @@ func (g *Go) exportValueGet(proc *exec.Process, sp int32) {
 
 	gtr, ok := v.v.(getter)
 	if !ok {
-		fmt.Println(g.values)
-		log.Printf("value %v of type %v is not a getter wanting %q", v.ref, reflect.TypeOf(v.v), s)
+		g.logger.Errorf("value %v of type %v is not a getter wanting %q", v.ref, reflect.TypeOf(v.v), s)
 		proc.Terminate()
 		return
 	}
@@ -437,8 +1331,166 @@ func (g *Go) exportValueGet(proc *exec.Process, sp int32) {
 	g.storeValue(proc, sp+32, gtr.Get(s))
 }
 
+func (g *Go) exportValueSet(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportValueSet")
+	v := g.loadValue(proc, sp+8)
+
+	s, err := g.loadString(proc, sp+16)
+	if err != nil {
+		panic(err)
+	}
+
+	x := g.loadValue(proc, sp+32)
+
+	str, ok := v.v.(setter)
+	if !ok {
+		g.logger.Errorf("value %v of type %v is not a setter wanting %q", v.ref, reflect.TypeOf(v.v), s)
+		proc.Terminate()
+		return
+	}
+
+	str.Set(s, x.v)
+}
+
+func (g *Go) exportValueIndex(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportValueIndex")
+	v := g.loadValue(proc, sp+8)
+	i := g.getInt64(proc, sp+16)
+
+	idx, ok := v.v.(indexer)
+	if !ok {
+		g.logger.Errorf("value %v of type %v is not an indexer", v.ref, reflect.TypeOf(v.v))
+		proc.Terminate()
+		return
+	}
+
+	g.storeValue(proc, sp+24, idx.Index(int(i)))
+}
+
+func (g *Go) exportValueSetIndex(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportValueSetIndex")
+	v := g.loadValue(proc, sp+8)
+	i := g.getInt64(proc, sp+16)
+	x := g.loadValue(proc, sp+24)
+
+	idx, ok := v.v.(indexSetter)
+	if !ok {
+		g.logger.Errorf("value %v of type %v is not an indexSetter", v.ref, reflect.TypeOf(v.v))
+		proc.Terminate()
+		return
+	}
+
+	idx.SetIndex(int(i), x.v)
+}
+
+func (g *Go) exportValueLength(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportValueLength")
+	v := g.loadValue(proc, sp+8)
+
+	l, ok := v.v.(lengther)
+	if !ok {
+		g.setInt64(proc, sp+16, 0)
+		return
+	}
+
+	g.setInt64(proc, sp+16, int64(l.Length()))
+}
+
+func (g *Go) exportValueInvoke(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportValueInvoke")
+	v := g.loadValue(proc, sp+8)
+	args := g.loadSliceOfValues(proc, sp+16)
+
+	inv, ok := v.v.(invoker)
+	if !ok {
+		err := fmt.Errorf("value %v of type %q is not an invoker", v, reflect.TypeOf(v.v))
+
+		g.storeValue(proc, sp+40, newJSError(err))
+		g.setUInt8(proc, sp+48, 0)
+		return
+	}
+
+	g.storeValue(proc, sp+40, inv.Invoke(args...))
+	g.setUInt8(proc, sp+48, 1)
+}
+
+func (g *Go) exportCopyBytesToGo(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportCopyBytesToGo")
+	dst, err := g.loadSlice(proc, sp+8)
+	if err != nil {
+		panic(err)
+	}
+
+	src, ok := g.loadValue(proc, sp+32).v.(jsUint8Array)
+	if !ok {
+		g.setUInt8(proc, sp+48, 0)
+		return
+	}
+
+	n := copy(dst, src)
+	g.setInt64(proc, sp+40, int64(n))
+	g.setUInt8(proc, sp+48, 1)
+}
+
+func (g *Go) exportCopyBytesToJS(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportCopyBytesToJS")
+	dst, ok := g.loadValue(proc, sp+8).v.(jsUint8Array)
+	if !ok {
+		g.setUInt8(proc, sp+48, 0)
+		return
+	}
+
+	src, err := g.loadSlice(proc, sp+16)
+	if err != nil {
+		panic(err)
+	}
+
+	n := copy(dst, src)
+	g.setInt64(proc, sp+40, int64(n))
+	g.setUInt8(proc, sp+48, 1)
+}
+
+func (g *Go) exportScheduleTimeoutEvent(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportScheduleTimeoutEvent")
+	delay := g.getInt64(proc, sp+8)
+
+	id := g.nextTimeoutID
+	g.nextTimeoutID++
+
+	g.timeouts[id] = time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+		g.fireTimeout(id)
+	})
+
+	g.setInt32(proc, sp+16, int32(id))
+}
+
+func (g *Go) exportClearTimeoutEvent(proc *exec.Process, sp int32) {
+	g.logger.Tracef("exportClearTimeoutEvent")
+	id := ref(g.getInt32(proc, sp+8))
+
+	if t, ok := g.timeouts[id]; ok {
+		t.Stop()
+		delete(g.timeouts, id)
+	}
+}
+
+// fireTimeout is invoked when a scheduled timeout fires. It drains the
+// guest's event loop the same way a real browser setTimeout would. It
+// runs on its own time.AfterFunc goroutine, so it takes g.vmMu itself
+// rather than relying on a caller already holding it.
+func (g *Go) fireTimeout(id ref) {
+	delete(g.timeouts, id)
+
+	g.vmMu.Lock()
+	defer g.vmMu.Unlock()
+
+	if err := g.resumeLocked(); err != nil {
+		g.logger.Warnf("fireTimeout: resume failed: %v", err)
+	}
+}
+
 func (g *Go) exportWalltime(proc *exec.Process, sp int32) {
-	fmt.Println("exportWalltime")
+	g.logger.Tracef("exportWalltime")
 	nsec := time.Now().UnixNano()
 	secs := nsec / 1e9
 	nsec = nsec - (secs * 1e9)
@@ -447,12 +1499,12 @@ func (g *Go) exportWalltime(proc *exec.Process, sp int32) {
 }
 
 func (g *Go) exportNanotime(proc *exec.Process, sp int32) {
-	fmt.Println("exportNanotime")
+	g.logger.Tracef("exportNanotime")
 	g.setInt64(proc, sp+8, time.Since(g.timeOrigin).Nanoseconds())
 }
 
 func (g *Go) exportGetRandomData(proc *exec.Process, sp int32) {
-	fmt.Println("exportGetRandomData")
+	g.logger.Tracef("exportGetRandomData")
 	s, err := g.loadSlice(proc, sp)
 	if err != nil {
 		panic(err)
@@ -461,16 +1513,21 @@ func (g *Go) exportGetRandomData(proc *exec.Process, sp int32) {
 }
 
 func (g *Go) exportWasmWrite(proc *exec.Process, sp int32) {
-	fmt.Println("exportWasmWrite")
+	g.logger.Tracef("exportWasmWrite")
+	fd := g.getInt64(proc, sp+8)
 	s, err := g.loadString(proc, sp+16)
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Print(s)
+	if fd == 2 {
+		fmt.Fprint(g.stderr, s)
+		return
+	}
+	fmt.Fprint(g.stdout, s)
 }
 
 func (g *Go) exportExit(proc *exec.Process, sp int32) {
-	fmt.Println("exportExit")
+	g.logger.Tracef("exportExit")
 	proc.Terminate()
 }